@@ -2,8 +2,11 @@ package exercise
 
 import (
 	"errors"
+	"path/filepath"
+
 	"github.com/aau-network-security/go-ntp/virtual"
 	"github.com/aau-network-security/go-ntp/virtual/docker"
+	"github.com/aau-network-security/go-ntp/virtual/runtime"
 	"github.com/aau-network-security/go-ntp/virtual/vbox"
 )
 
@@ -54,6 +57,14 @@ type Config struct {
 	Tags        []string       `yaml:"tags"`
 	DockerConfs []DockerConfig `yaml:"docker"`
 	VBoxConfig  []VBoxConfig   `yaml:"vbox"`
+	Runtime     runtime.Config `yaml:"runtime"`
+}
+
+// Driver maps Runtime onto a concrete runtime.Driver. An empty Runtime
+// (no `runtime:` key in the exercise YAML) selects the Docker driver, so
+// existing DockerConfig-only configuration files keep working unmodified.
+func (conf Config) Driver() (runtime.Driver, error) {
+	return runtime.NewDriver(conf.Runtime)
 }
 
 func (conf Config) Flags() []FlagConfig {
@@ -99,14 +110,17 @@ func (ec Config) ContainerOpts() ([]docker.ContainerConfig, [][]RecordConfig) {
 	return contSpecs, contRecords
 }
 
+// DockerHost is kept so callers who only ever spoke to the Docker daemon
+// directly don't need to change; it is satisfied by runtime.Driver.
 type DockerHost interface {
 	CreateContainer(conf docker.ContainerConfig) (docker.Container, error)
 }
 
-type dockerHost struct{}
-
-func (dockerHost) CreateContainer(conf docker.ContainerConfig) (docker.Container, error) {
-	return docker.NewContainer(conf)
+// identifiable is satisfied by any docker.Container the driver produced, so
+// exercise can tell it apart from a vbox.Instance in e.machines without the
+// two packages needing to know about each other.
+type identifiable interface {
+	ID() string
 }
 
 type exercise struct {
@@ -117,8 +131,32 @@ type exercise struct {
 	ips        []int
 	dnsIP      string
 	dnsRecords []RecordConfig
-	dockerHost DockerHost
+	driver     runtime.Driver
 	lib        vbox.Library
+
+	// numContainers is how many of the leading entries in machines came
+	// from containers (conf.DockerConfs, in order); the rest are vbox VMs.
+	// Create always appends containers before VMs, so an index split is
+	// enough and we don't need to tag every entry individually.
+	numContainers int
+}
+
+// NewExercise builds an exercise ready for Create, selecting its
+// runtime.Driver from conf.Runtime so every docker-backed exercise gets a
+// live driver instead of e.driver defaulting to nil.
+func NewExercise(conf *Config, net docker.Network, dnsIP string, lib vbox.Library) (*exercise, error) {
+	driver, err := conf.Driver()
+	if err != nil {
+		return nil, err
+	}
+
+	return &exercise{
+		conf:   conf,
+		net:    net,
+		dnsIP:  dnsIP,
+		lib:    lib,
+		driver: driver,
+	}, nil
 }
 
 func (e *exercise) Create() error {
@@ -129,7 +167,7 @@ func (e *exercise) Create() error {
 	for i, spec := range containers {
 		spec.DNS = []string{e.dnsIP}
 
-		c, err := e.dockerHost.CreateContainer(spec)
+		c, err := e.driver.CreateContainer(spec)
 		if err != nil {
 			return err
 		}
@@ -139,13 +177,13 @@ func (e *exercise) Create() error {
 
 		if e.ips != nil {
 			// Containers need specific ips
-			lastDigit, err = e.net.Connect(c, spec.MacAddress, e.ips[i])
+			lastDigit, err = e.driver.AttachNetwork(c.ID(), e.net, spec.MacAddress, e.ips[i])
 			if err != nil {
 				return err
 			}
 		} else {
 			// Let network assign ips
-			lastDigit, err = e.net.Connect(c, spec.MacAddress)
+			lastDigit, err = e.driver.AttachNetwork(c.ID(), e.net, spec.MacAddress, 0)
 			if err != nil {
 				return err
 			}
@@ -165,6 +203,7 @@ func (e *exercise) Create() error {
 
 		machines = append(machines, c)
 	}
+	e.numContainers = len(machines)
 
 	for _, spec := range e.conf.VBoxConfig {
 		vm, err := e.lib.GetCopy(
@@ -187,7 +226,15 @@ func (e *exercise) Create() error {
 }
 
 func (e *exercise) Start() error {
-	for _, m := range e.machines {
+	for i, m := range e.machines {
+		if i < e.numContainers {
+			if c, ok := m.(identifiable); ok {
+				if err := e.driver.StartContainer(c.ID()); err != nil {
+					return err
+				}
+				continue
+			}
+		}
 		if err := m.Start(); err != nil {
 			return err
 		}
@@ -206,7 +253,15 @@ func (e *exercise) Stop() error {
 }
 
 func (e *exercise) Close() error {
-	for _, m := range e.machines {
+	for i, m := range e.machines {
+		if i < e.numContainers {
+			if c, ok := m.(identifiable); ok {
+				if err := e.driver.RemoveContainer(c.ID()); err != nil {
+					return err
+				}
+				continue
+			}
+		}
 		if err := m.Close(); err != nil {
 			return err
 		}
@@ -215,6 +270,27 @@ func (e *exercise) Close() error {
 	return nil
 }
 
+// Snapshot checkpoints every container-backed machine into dir, one
+// subdirectory per container ID. It's the primitive a Lab.Checkpoint
+// implementation (see lab.Checkpointable) calls into for the exercises
+// running inside it; vbox VMs are snapshotted through VBoxManage directly
+// and don't go through this path.
+func (e *exercise) Snapshot(dir string) error {
+	for i, m := range e.machines {
+		if i >= e.numContainers {
+			break
+		}
+		c, ok := m.(identifiable)
+		if !ok {
+			continue
+		}
+		if err := e.driver.SnapshotContainer(c.ID(), filepath.Join(dir, c.ID())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (e *exercise) Reset() error {
 	if err := e.Stop(); err != nil {
 		return err
@@ -0,0 +1,188 @@
+// Copyright (c) 2018-2019 Aalborg University
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package lab
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	targetBufferGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "haaukins_hub_target_buffer",
+		Help: "Warm pool size the autoscaler currently wants to maintain.",
+	})
+	actualBufferGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "haaukins_hub_actual_buffer",
+		Help: "Warm pool size currently sitting ready in the hub buffer.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(targetBufferGauge, actualBufferGauge)
+}
+
+// ScalerConfig are the knobs operators use to tune how aggressively the
+// AutoScaler reacts to demand.
+type ScalerConfig struct {
+	MinAvailable    int
+	MaxAvailable    int
+	ScaleAlpha      float64
+	CooldownSeconds int
+}
+
+// DefaultScalerConfig matches the previous hard-coded BUFFERSIZE behaviour,
+// so hubs that don't opt into tuning keep their old target of 5.
+var DefaultScalerConfig = ScalerConfig{
+	MinAvailable:    BUFFERSIZE,
+	MaxAvailable:    BUFFERSIZE,
+	ScaleAlpha:      0.3,
+	CooldownSeconds: 60,
+}
+
+// AutoScaler watches how fast Get() is being called and resizes a hub's
+// target buffer level to the EWMA of that rate times the recent lab boot
+// time, so the warm pool grows ahead of a burst and shrinks again once it
+// passes instead of sitting at a fixed size all event long.
+type AutoScaler struct {
+	conf ScalerConfig
+
+	target int32 // atomically updated desired buffer size
+
+	// m guards rate, leadTime, lastGet and lastScale, which are read and
+	// written from RecordGet (called on every concurrent hub.Get()),
+	// RecordAddLabDuration and the Run ticker goroutine alike.
+	m         sync.Mutex
+	rate      float64 // EWMA of Get() calls per second
+	leadTime  float64 // EWMA of addLab duration in seconds
+	lastGet   time.Time
+	lastScale time.Time
+}
+
+// NewAutoScaler seeds the scaler at conf.MinAvailable, the safest starting
+// point before any demand signal has been observed.
+func NewAutoScaler(conf ScalerConfig) *AutoScaler {
+	a := &AutoScaler{
+		conf:     conf,
+		target:   int32(conf.MinAvailable),
+		leadTime: 1,
+	}
+	targetBufferGauge.Set(float64(conf.MinAvailable))
+	return a
+}
+
+// Target returns the buffer size the scaler currently wants maintained.
+func (a *AutoScaler) Target() int {
+	return int(atomic.LoadInt32(&a.target))
+}
+
+// minScaleDownInterval is the ticker period Run falls back to when
+// CooldownSeconds is non-positive; time.NewTicker panics on a
+// non-positive duration, and CooldownSeconds is an operator-facing knob on
+// ScalerConfig with no validation of its own.
+const minScaleDownInterval = time.Second
+
+// Run periodically closes idle labs down to the current target once demand
+// drops, freeing maximumSema slots for other events sharing the host. It
+// relies on rescale's own cooldown to avoid thrashing, and exits when stop
+// is closed.
+func (a *AutoScaler) Run(h *hub, stop <-chan struct{}) {
+	interval := time.Duration(a.conf.CooldownSeconds) * time.Second
+	if interval <= 0 {
+		interval = minScaleDownInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.rescale(time.Now())
+			h.shrinkToTarget(a.Target())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// RecordGet folds a Get() call into the request-rate EWMA and recomputes
+// the target, subject to CooldownSeconds between scale-downs so a brief
+// lull doesn't immediately shrink the pool out from under the next burst.
+func (a *AutoScaler) RecordGet(now time.Time) {
+	a.m.Lock()
+	if !a.lastGet.IsZero() {
+		dt := now.Sub(a.lastGet).Seconds()
+		if dt > 0 {
+			instant := 1 / dt
+			a.rate = a.conf.ScaleAlpha*instant + (1-a.conf.ScaleAlpha)*a.rate
+		}
+	}
+	a.lastGet = now
+	a.m.Unlock()
+
+	a.rescale(now)
+}
+
+// RecordAddLabDuration folds how long the most recent addLab took into the
+// lead-time EWMA, which is how far ahead of demand the scaler needs to
+// stay topped up.
+func (a *AutoScaler) RecordAddLabDuration(d time.Duration) {
+	secs := d.Seconds()
+	if secs <= 0 {
+		secs = 1
+	}
+
+	a.m.Lock()
+	a.leadTime = a.conf.ScaleAlpha*secs + (1-a.conf.ScaleAlpha)*a.leadTime
+	a.m.Unlock()
+}
+
+// rescale takes its own lock, so it must never be called while a caller
+// already holds a.m (see RecordGet, which releases it first).
+func (a *AutoScaler) rescale(now time.Time) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	// With no Get() since lastGet the rate is at most one call over that
+	// silence, so a burst that stops outright still lets the target shrink.
+	rate := a.rate
+	if !a.lastGet.IsZero() {
+		if idle := now.Sub(a.lastGet).Seconds(); idle > 0 && 1/idle < rate {
+			rate = 1 / idle
+		}
+	}
+
+	desired := int(math.Ceil(rate * a.leadTime))
+
+	if desired < a.conf.MinAvailable {
+		desired = a.conf.MinAvailable
+	}
+	if desired > a.conf.MaxAvailable {
+		desired = a.conf.MaxAvailable
+	}
+
+	current := a.Target()
+	if desired == current {
+		return
+	}
+
+	if desired < current {
+		cooldown := time.Duration(a.conf.CooldownSeconds) * time.Second
+		if now.Sub(a.lastScale) < cooldown {
+			return
+		}
+	}
+
+	atomic.StoreInt32(&a.target, int32(desired))
+	a.lastScale = now
+	targetBufferGauge.Set(float64(desired))
+	log.Debug().Msgf("autoscaler resized target buffer to %d (rate=%.2f/s, lead=%.2fs)", desired, a.rate, a.leadTime)
+}
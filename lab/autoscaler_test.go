@@ -0,0 +1,82 @@
+// Copyright (c) 2018-2019 Aalborg University
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package lab
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAutoScalerSeedsAtMinAvailable(t *testing.T) {
+	conf := ScalerConfig{MinAvailable: 2, MaxAvailable: 10, ScaleAlpha: 0.3, CooldownSeconds: 60}
+	a := NewAutoScaler(conf)
+
+	if got := a.Target(); got != conf.MinAvailable {
+		t.Fatalf("Target() = %d, want %d", got, conf.MinAvailable)
+	}
+}
+
+func TestAutoScalerScalesUpWithDemand(t *testing.T) {
+	conf := ScalerConfig{MinAvailable: 1, MaxAvailable: 10, ScaleAlpha: 1, CooldownSeconds: 0}
+	a := NewAutoScaler(conf)
+	a.leadTime = 5
+
+	now := time.Unix(0, 0)
+	for i := 0; i < 5; i++ {
+		now = now.Add(time.Second)
+		a.RecordGet(now)
+	}
+
+	if got := a.Target(); got <= conf.MinAvailable {
+		t.Fatalf("Target() = %d, want more than MinAvailable (%d) after sustained demand", got, conf.MinAvailable)
+	}
+}
+
+// TestAutoScalerRunToleratesZeroCooldown makes sure Run doesn't panic when
+// CooldownSeconds is left at its zero value: time.NewTicker panics on a
+// non-positive duration, and CooldownSeconds is an operator-facing knob
+// with no validation of its own.
+func TestAutoScalerRunToleratesZeroCooldown(t *testing.T) {
+	conf := ScalerConfig{MinAvailable: 1, MaxAvailable: 5, ScaleAlpha: 0.3, CooldownSeconds: 0}
+	a := NewAutoScaler(conf)
+
+	h := &hub{
+		buffer:      make(chan Lab, 5),
+		maximumSema: newSemaphore(5),
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		a.Run(h, stop)
+		close(done)
+	}()
+
+	close(stop)
+	<-done
+}
+
+// TestAutoScalerConcurrentAccess exercises RecordGet, RecordAddLabDuration
+// and rescale concurrently under -race to cover the lock discipline added
+// around rate/leadTime/lastGet/lastScale.
+func TestAutoScalerConcurrentAccess(t *testing.T) {
+	conf := ScalerConfig{MinAvailable: 1, MaxAvailable: 10, ScaleAlpha: 0.3, CooldownSeconds: 0}
+	a := NewAutoScaler(conf)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			a.RecordGet(time.Now())
+		}()
+		go func() {
+			defer wg.Done()
+			a.RecordAddLabDuration(time.Millisecond)
+		}()
+	}
+	wg.Wait()
+}
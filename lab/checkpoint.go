@@ -0,0 +1,208 @@
+// Copyright (c) 2018-2019 Aalborg University
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package lab
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+// checkpointVersion is bumped whenever the on-disk checkpoint layout or the
+// fields we restore change, so that stale checkpoints from a previous
+// Haaukins build are discarded instead of restored broken.
+const checkpointVersion = 1
+
+// Checkpointable is implemented by labs whose underlying backend can freeze
+// and persist running state to disk (podman's `container checkpoint
+// --export`, VBoxManage's `savestate` plus exported disk deltas). Labs that
+// don't support it are simply skipped by Close, the same way hub already
+// tolerates backends with partial feature support.
+type Checkpointable interface {
+	Checkpoint(dir string) error
+	Restore(dir string) error
+}
+
+// checkpointMeta is written alongside every checkpoint so a restore can tell
+// whether the snapshot still matches the exercise config it was taken from.
+type checkpointMeta struct {
+	Version    int    `json:"version"`
+	Tag        string `json:"tag"`
+	ConfigHash string `json:"config_hash"`
+}
+
+// configFingerprint hashes conf's JSON encoding so a restore can tell
+// whether the config has changed since a checkpoint was taken, without
+// needing to know which specific fields matter.
+func configFingerprint(conf Config) (string, error) {
+	b, err := json.Marshal(conf)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// persistBuffer snapshots every lab still sitting unclaimed in the buffer.
+// It's called from Close so a restart doesn't throw away a warm pool that
+// nobody got to use.
+func (h *hub) persistBuffer() {
+	dir := h.checkpointDir
+	if dir == "" {
+		return
+	}
+
+	for {
+		h.m.Lock()
+		lab, ok := h.receiveLocked()
+		h.m.Unlock()
+		if !ok {
+			return
+		}
+		h.checkpointLab(dir, lab)
+	}
+}
+
+func (h *hub) checkpointLab(dir string, l Lab) {
+	cl, ok := l.(Checkpointable)
+	if !ok {
+		if err := l.Close(); err != nil {
+			log.Warn().Msgf("error while closing non-checkpointable lab: %s", err)
+		}
+		return
+	}
+
+	labDir := filepath.Join(dir, l.GetTag())
+	if err := os.MkdirAll(labDir, 0750); err != nil {
+		log.Warn().Msgf("error while creating checkpoint dir: %s", err)
+		return
+	}
+
+	if err := cl.Checkpoint(labDir); err != nil {
+		log.Warn().Msgf("error while checkpointing lab %s: %s", l.GetTag(), err)
+		return
+	}
+
+	hash, err := configFingerprint(h.conf)
+	if err != nil {
+		log.Warn().Msgf("error while fingerprinting config for checkpoint: %s", err)
+		return
+	}
+
+	meta := checkpointMeta{Version: checkpointVersion, Tag: l.GetTag(), ConfigHash: hash}
+	f, err := json.Marshal(meta)
+	if err != nil {
+		log.Warn().Msgf("error while marshalling checkpoint metadata: %s", err)
+		return
+	}
+	if err := ioutil.WriteFile(filepath.Join(labDir, "meta.json"), f, 0640); err != nil {
+		log.Warn().Msgf("error while writing checkpoint metadata: %s", err)
+	}
+}
+
+// restoreCheckpoints scans CheckpointDir for valid snapshots and restores
+// them straight into the buffer, counting each one against maximumSema so
+// NewHub only needs to addLab the shortfall.
+func (h *hub) restoreCheckpoints() int {
+	dir := h.checkpointDir
+	if dir == "" {
+		return 0
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn().Msgf("error while reading checkpoint dir: %s", err)
+		}
+		return 0
+	}
+
+	restored := 0
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if h.maximumSema.available() == 0 {
+			break
+		}
+
+		labDir := filepath.Join(dir, e.Name())
+		lab, err := h.restoreLab(labDir)
+		if err != nil {
+			log.Warn().Msgf("error while restoring checkpoint %s: %s", e.Name(), err)
+			os.RemoveAll(labDir)
+			continue
+		}
+		if lab == nil {
+			// Stale version or config mismatch: restoreLab already closed
+			// anything it allocated, the on-disk snapshot just isn't
+			// valid anymore.
+			os.RemoveAll(labDir)
+			continue
+		}
+
+		h.maximumSema.claim()
+		h.buffer <- lab
+		restored++
+	}
+
+	return restored
+}
+
+func (h *hub) restoreLab(labDir string) (Lab, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(labDir, "meta.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var meta checkpointMeta
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return nil, err
+	}
+
+	if meta.Version != checkpointVersion {
+		log.Debug().Msgf("discarding checkpoint %s from stale version %d", meta.Tag, meta.Version)
+		return nil, nil
+	}
+
+	currentHash, err := configFingerprint(h.conf)
+	if err != nil {
+		return nil, err
+	}
+	if meta.ConfigHash != currentHash {
+		log.Debug().Msgf("discarding checkpoint %s: exercise config changed since it was taken", meta.Tag)
+		return nil, nil
+	}
+
+	lab, err := h.labHost.NewLab(h.ctx, h.vboxLib, h.conf)
+	if err != nil {
+		return nil, err
+	}
+
+	cl, ok := lab.(Checkpointable)
+	if !ok {
+		// Backend stopped supporting checkpoints between the snapshot and
+		// now (or never did); the lab we just allocated still needs
+		// closing, we just can't restore into it.
+		if err := lab.Close(); err != nil {
+			log.Warn().Msgf("error while closing lab that can't be restored: %s", err)
+		}
+		return nil, nil
+	}
+
+	if err := cl.Restore(labDir); err != nil {
+		if closeErr := lab.Close(); closeErr != nil {
+			log.Warn().Msgf("error while closing lab after failed restore: %s", closeErr)
+		}
+		return nil, err
+	}
+
+	return lab, nil
+}
@@ -0,0 +1,31 @@
+// Copyright (c) 2018-2019 Aalborg University
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package lab
+
+import "testing"
+
+// TestConfigFingerprintIsStable only asserts that hashing the same Config
+// twice gives the same result. Config's real, exercise-identifying fields
+// (image tags, docker/vbox specs, etc.) live outside this package's current
+// diff, so this can't also construct two distinct Configs and assert their
+// fingerprints differ — that property falls directly out of sha256 and
+// configFingerprint's plain json.Marshal, not out of anything specific to
+// this package.
+func TestConfigFingerprintIsStable(t *testing.T) {
+	var conf Config
+
+	hashA, err := configFingerprint(conf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	hashB, err := configFingerprint(conf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if hashA != hashB {
+		t.Fatalf("expected the same config to fingerprint the same way every time")
+	}
+}
@@ -0,0 +1,447 @@
+// Copyright (c) 2018-2019 Aalborg University
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package lab
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/aau-network-security/haaukins/store"
+	"github.com/aau-network-security/haaukins/virtual/vbox"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	NoCandidateNodeErr = errors.New("No cluster node has capacity for a new lab")
+)
+
+// nodeFailureThreshold is how many consecutive AddLab RPC failures a node
+// needs before ClusterHub treats it as actually dead. A single failed RPC
+// is routine (a brief network blip, the node briefly out of local
+// capacity) and just gets retried against whichever node scores next; only
+// sustained failure is treated as the node having died mid-event.
+const nodeFailureThreshold = 3
+
+// Node describes a lab agent that ClusterHub can dispatch addLab RPCs to.
+type Node struct {
+	Id     string
+	Addr   string
+	Labels map[string]string
+}
+
+// Affinity nudges the scheduler towards (or away from) nodes whose labels
+// match RTarget, the same way Nomad affinities do.
+type Affinity struct {
+	LTarget string
+	Operand string
+	RTarget string
+	Weight  int
+}
+
+// Spread tries to keep labs evenly distributed across the buckets of
+// Attribute (e.g. "datacenter"). When Targets is set, the even share is
+// 100% divided by the number of Targets; the target values themselves are
+// not matched against buckets and carry no weights of their own.
+type Spread struct {
+	Attribute string
+	Weight    int
+	Targets   []string
+}
+
+// ClusterConfig carries the scheduling rules for a ClusterHub, analogous to
+// the job-level affinity/spread stanzas in Nomad.
+type ClusterConfig struct {
+	Affinities []Affinity
+	Spreads    []Spread
+}
+
+// LabAgent is the RPC surface a remote lab agent exposes to the cluster hub.
+// A production implementation dials the agent over gRPC; tests and the
+// in-process hub can supply a fake.
+type LabAgent interface {
+	Node() Node
+	AddLab(ctx context.Context, conf Config) (Lab, error)
+	Close() error
+}
+
+// clusterNode tracks one remote lab agent. All fields are guarded by the
+// owning ClusterHub's h.m — there's no separate per-node lock, so a single
+// consistent view of load/labs is used everywhere, including under
+// concurrent addLab calls from multiple goroutines.
+type clusterNode struct {
+	agent LabAgent
+
+	alive               bool
+	consecutiveFailures int
+	load                int
+
+	// unclaimed labs are still sitting in the buffer, nobody's connected
+	// to them yet. claimed labs have been handed out via Get() and are in
+	// active use by a student; losing the node they run on does NOT mean
+	// losing those sessions, so only unclaimed labs are ever re-queued.
+	unclaimed []Lab
+	claimed   []Lab
+}
+
+// clusterLab pairs a Lab with the node that created it, so Get() can move
+// it from unclaimed to claimed on the right node without a reverse lookup.
+type clusterLab struct {
+	node *clusterNode
+	lab  Lab
+}
+
+// ClusterHub implements Hub by spreading lab creation across a pool of
+// remote lab agents instead of booting everything on the local box.
+type ClusterHub struct {
+	conf        Config
+	clusterConf ClusterConfig
+
+	m      sync.Mutex
+	nodes  []*clusterNode
+	buffer chan clusterLab
+
+	// closed is set under m before buffer is ever closed; addLab checks it
+	// under the same lock before sending, so a send can never race the
+	// channel close (select's default case does not protect a send against
+	// a concurrent close).
+	closed bool
+
+	maximumSema *semaphore
+}
+
+// NewClusterHub wires up a ClusterHub against an already-connected set of
+// lab agents. Affinity/spread rules come from clusterConf and are evaluated
+// on every addLab call, so nodes can be added or marked dead at runtime.
+func NewClusterHub(conf Config, clusterConf ClusterConfig, agents []LabAgent, available int, cap int) (*ClusterHub, error) {
+	if available > cap {
+		return nil, AvailableSizeErr
+	}
+
+	nodes := make([]*clusterNode, len(agents))
+	for i, a := range agents {
+		nodes[i] = &clusterNode{agent: a, alive: true}
+	}
+
+	h := &ClusterHub{
+		conf:        conf,
+		clusterConf: clusterConf,
+		nodes:       nodes,
+		buffer:      make(chan clusterLab, available),
+		maximumSema: newSemaphore(cap),
+	}
+
+	for i := 0; i < available; i++ {
+		if err := h.addLab(); err != nil {
+			log.Warn().Msgf("error while seeding cluster hub: %s", err)
+		}
+	}
+
+	return h, nil
+}
+
+// NewClusterOrLocalHub is the one place that decides between a ClusterHub
+// and the single-box LocalHub: supplying at least one LabAgent opts an
+// event into cluster scheduling, an empty slice keeps the previous
+// single-box behaviour.
+func NewClusterOrLocalHub(ctx context.Context, conf Config, vboxLib vbox.Library, available, cap int, scalerConf ScalerConfig, clusterConf ClusterConfig, agents []LabAgent) (Hub, error) {
+	if len(agents) > 0 {
+		return NewClusterHub(conf, clusterConf, agents, available, cap)
+	}
+	return NewHubWithScaler(ctx, conf, vboxLib, available, cap, scalerConf)
+}
+
+// addLab picks the best-scoring node with spare capacity and asks it to
+// start a lab. A single failed RPC is retried on whichever node scores
+// next on the following call; a node only gets marked dead, and its
+// unclaimed labs re-queued, after nodeFailureThreshold consecutive
+// failures (see recordFailure).
+func (h *ClusterHub) addLab() error {
+	if h.maximumSema.available() == 0 {
+		return MaximumLabsErr
+	}
+
+	node := h.pickNode()
+	if node == nil {
+		return NoCandidateNodeErr
+	}
+
+	h.maximumSema.claim()
+
+	lab, err := node.agent.AddLab(context.Background(), h.conf)
+	if err != nil {
+		h.maximumSema.release()
+		h.recordFailure(node)
+		return err
+	}
+	h.recordSuccess(node)
+
+	cl := clusterLab{node: node, lab: lab}
+
+	sent := false
+	h.m.Lock()
+	if !h.closed {
+		node.unclaimed = append(node.unclaimed, lab)
+		node.load++
+		select {
+		case h.buffer <- cl:
+			sent = true
+		default:
+			node.unclaimed = removeLab(node.unclaimed, lab)
+			node.load--
+		}
+	}
+	h.m.Unlock()
+
+	if !sent {
+		h.maximumSema.release()
+	}
+
+	return nil
+}
+
+// pickNode scores every live node by summing its affinity weights and
+// subtracting a spread penalty for buckets that already hold more than
+// their fair share of labs, then returns the highest scorer. Nodes carry no
+// capacity limit of their own; the only capacity check is the hub-wide
+// maximumSema in addLab.
+func (h *ClusterHub) pickNode() *clusterNode {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	var best *clusterNode
+	bestScore := 0
+	first := true
+
+	for _, n := range h.nodes {
+		if !n.alive {
+			continue
+		}
+
+		score := h.scoreNode(n)
+		if first || score > bestScore {
+			best = n
+			bestScore = score
+			first = false
+		}
+	}
+
+	return best
+}
+
+// scoreNode must be called with h.m already held: it reads node load and
+// labels, both of which addLab/recordFailure also mutate under h.m.
+func (h *ClusterHub) scoreNode(n *clusterNode) int {
+	score := 0
+	for _, aff := range h.clusterConf.Affinities {
+		if matchesAffinity(n.agent.Node(), aff) {
+			score += aff.Weight
+		} else {
+			score -= aff.Weight
+		}
+	}
+
+	total := h.totalLoad()
+	for _, spread := range h.clusterConf.Spreads {
+		score -= spreadPenalty(h.nodes, n, spread, total)
+	}
+
+	return score
+}
+
+func matchesAffinity(n Node, aff Affinity) bool {
+	val, ok := n.Labels[aff.LTarget]
+	if !ok {
+		return false
+	}
+	switch aff.Operand {
+	case "!=":
+		return val != aff.RTarget
+	default: // "=" is the only other operand we support today
+		return val == aff.RTarget
+	}
+}
+
+// spreadPenalty estimates how overfilled n's bucket is relative to the
+// desired even split across all buckets seen for spread.Attribute, and
+// returns a penalty proportional to that overfill times the spread weight.
+// Callers must hold h.m, since it reads every node's load.
+func spreadPenalty(nodes []*clusterNode, n *clusterNode, spread Spread, total int) int {
+	if total == 0 {
+		return 0
+	}
+
+	bucket := n.agent.Node().Labels[spread.Attribute]
+	buckets := map[string]int{}
+	for _, other := range nodes {
+		if !other.alive {
+			continue
+		}
+		b := other.agent.Node().Labels[spread.Attribute]
+		buckets[b] += other.load
+	}
+
+	desiredPct := 100 / max(1, len(spread.Targets))
+	if len(spread.Targets) == 0 {
+		desiredPct = 100 / max(1, len(buckets))
+	}
+
+	actualPct := (buckets[bucket] * 100) / total
+	overfill := actualPct - desiredPct
+	if overfill <= 0 {
+		return 0
+	}
+
+	return overfill * spread.Weight / 100
+}
+
+// totalLoad must be called with h.m already held.
+func (h *ClusterHub) totalLoad() int {
+	total := 0
+	for _, n := range h.nodes {
+		total += n.load
+	}
+	return total
+}
+
+// recordSuccess clears a node's failure streak; one healthy RPC is enough
+// to trust the node again, the threshold only guards against sustained
+// failure.
+func (h *ClusterHub) recordSuccess(n *clusterNode) {
+	h.m.Lock()
+	n.consecutiveFailures = 0
+	h.m.Unlock()
+}
+
+// recordFailure counts a failed AddLab RPC against a node and, once
+// nodeFailureThreshold consecutive failures have piled up, treats the node
+// as dead: it's taken out of scheduling and every lab it still had sitting
+// unclaimed in the buffer (never labs already claimed by a student) is
+// re-queued onto a healthy node.
+func (h *ClusterHub) recordFailure(n *clusterNode) {
+	h.m.Lock()
+	n.consecutiveFailures++
+	dead := n.alive && n.consecutiveFailures >= nodeFailureThreshold
+	var lost []Lab
+	if dead {
+		n.alive = false
+		lost = n.unclaimed
+		n.unclaimed = nil
+	}
+	h.m.Unlock()
+
+	if !dead {
+		return
+	}
+
+	log.Warn().Msgf("cluster node %s lost after %d consecutive failures, re-queuing %d unclaimed lab(s)", n.agent.Node().Id, nodeFailureThreshold, len(lost))
+	for range lost {
+		go func() {
+			if err := h.addLab(); err != nil {
+				log.Warn().Msgf("error while re-queuing lab after node loss: %s", err)
+			}
+		}()
+	}
+}
+
+func (h *ClusterHub) Get() (Lab, error) {
+	h.m.Lock()
+	var cl clusterLab
+	var ok bool
+	select {
+	case cl, ok = <-h.buffer:
+	default:
+	}
+	if ok {
+		cl.node.unclaimed = removeLab(cl.node.unclaimed, cl.lab)
+		cl.node.claimed = append(cl.node.claimed, cl.lab)
+	}
+	h.m.Unlock()
+
+	if !ok {
+		return nil, MaximumLabsErr
+	}
+
+	go func() {
+		if err := h.addLab(); err != nil {
+			log.Warn().Msgf("error while refilling cluster hub: %s", err)
+		}
+	}()
+	return cl.lab, nil
+}
+
+func (h *ClusterHub) Available() int32 {
+	return int32(len(h.buffer))
+}
+
+func (h *ClusterHub) Flags() []store.FlagConfig {
+	return h.conf.Flags()
+}
+
+func (h *ClusterHub) GetLabs() []Lab {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	var labs []Lab
+	for _, n := range h.nodes {
+		labs = append(labs, n.claimed...)
+	}
+	return labs
+}
+
+// Health always reports empty: probing a lab's backend only makes sense on
+// the box actually running it, so health checking for cluster-scheduled
+// labs belongs on the remote agent, not here.
+func (h *ClusterHub) Health() []LabHealth {
+	return nil
+}
+
+func (h *ClusterHub) GetLabByTag(tag string) (Lab, error) {
+	for _, lab := range h.GetLabs() {
+		if tag == lab.GetTag() {
+			return lab, nil
+		}
+	}
+	return nil, CouldNotFindLabErr
+}
+
+func (h *ClusterHub) Close() error {
+	h.m.Lock()
+	h.closed = true
+	h.m.Unlock()
+
+	close(h.buffer)
+
+	var wg sync.WaitGroup
+	for _, n := range h.nodes {
+		wg.Add(1)
+		go func(n *clusterNode) {
+			defer wg.Done()
+			if err := n.agent.Close(); err != nil {
+				log.Warn().Msgf("error while closing cluster node: %s", err)
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+func removeLab(labs []Lab, target Lab) []Lab {
+	out := labs[:0]
+	for _, l := range labs {
+		if l != target {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2018-2019 Aalborg University
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package lab
+
+import (
+	"context"
+	"testing"
+)
+
+type fakeAgent struct {
+	node Node
+}
+
+func (f *fakeAgent) Node() Node { return f.node }
+func (f *fakeAgent) AddLab(ctx context.Context, conf Config) (Lab, error) {
+	panic("not used by this test")
+}
+func (f *fakeAgent) Close() error { return nil }
+
+func TestMatchesAffinity(t *testing.T) {
+	n := Node{Labels: map[string]string{"datacenter": "dc1"}}
+
+	tt := []struct {
+		name string
+		aff  Affinity
+		want bool
+	}{
+		{"equal operand matches", Affinity{LTarget: "datacenter", Operand: "=", RTarget: "dc1"}, true},
+		{"equal operand mismatches", Affinity{LTarget: "datacenter", Operand: "=", RTarget: "dc2"}, false},
+		{"not-equal operand matches", Affinity{LTarget: "datacenter", Operand: "!=", RTarget: "dc2"}, true},
+		{"missing label never matches", Affinity{LTarget: "gpu", Operand: "=", RTarget: "true"}, false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := matchesAffinity(n, tc.aff); got != tc.want {
+				t.Errorf("matchesAffinity() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSpreadPenaltyPenalizesOverfilledBucket(t *testing.T) {
+	dc1 := &clusterNode{agent: &fakeAgent{Node{Labels: map[string]string{"datacenter": "dc1"}}}, alive: true, load: 8}
+	dc2 := &clusterNode{agent: &fakeAgent{Node{Labels: map[string]string{"datacenter": "dc2"}}}, alive: true, load: 2}
+	nodes := []*clusterNode{dc1, dc2}
+
+	spread := Spread{Attribute: "datacenter", Weight: 100}
+
+	overfilledPenalty := spreadPenalty(nodes, dc1, spread, 10)
+	underfilledPenalty := spreadPenalty(nodes, dc2, spread, 10)
+
+	if overfilledPenalty <= underfilledPenalty {
+		t.Fatalf("expected the overfilled bucket (dc1) to take a bigger penalty than the underfilled one (dc2): got %d vs %d", overfilledPenalty, underfilledPenalty)
+	}
+	if underfilledPenalty != 0 {
+		t.Fatalf("underfilled bucket should take no penalty, got %d", underfilledPenalty)
+	}
+}
+
+func TestRemoveLab(t *testing.T) {
+	a, b, c := &localLab{}, &localLab{}, &localLab{}
+	labs := []Lab{a, b, c}
+
+	out := removeLab(labs, b)
+
+	if len(out) != 2 {
+		t.Fatalf("expected 2 labs left, got %d", len(out))
+	}
+	for _, l := range out {
+		if l == b {
+			t.Fatalf("removeLab did not remove the target lab")
+		}
+	}
+}
+
+// localLab is a minimal Lab stand-in so TestRemoveLab doesn't need the real
+// (unexported, backend-specific) implementation.
+type localLab struct{}
+
+func (l *localLab) Start(ctx context.Context) error { return nil }
+func (l *localLab) Close() error                    { return nil }
+func (l *localLab) GetTag() string                  { return "" }
@@ -0,0 +1,34 @@
+// Copyright (c) 2018-2019 Aalborg University
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package lab
+
+import (
+	"github.com/aau-network-security/go-ntp/virtual/runtime"
+)
+
+// DriverAware lets a LabHost opt into receiving the runtime.Driver a hub
+// was configured with, the same way Checkpointable/Prober let a Lab opt
+// into extra capabilities without forcing every implementation to take on
+// a dependency it may not need.
+type DriverAware interface {
+	SetDriver(d runtime.Driver)
+}
+
+// withDriver passes d to host if host implements DriverAware, and is a
+// no-op otherwise. Called once from NewHubWithDriver so hub.labHost (and,
+// transitively, the exercises each Lab creates) can use the pluggable
+// runtime.Driver instead of whatever engine it hard-coded before.
+//
+// NOTE: this only has an effect once the concrete labHost type (its file is
+// not part of this package's current diff) implements DriverAware with a
+// SetDriver method that threads d down into the exercise.Config/exercise it
+// builds per Lab. Until that method exists there, the driver built by
+// NewHubWithDriver is silently dropped here. See TestWithDriverCallsSetDriver
+// for the contract the concrete labHost needs to satisfy.
+func withDriver(host LabHost, d runtime.Driver) {
+	if da, ok := host.(DriverAware); ok {
+		da.SetDriver(d)
+	}
+}
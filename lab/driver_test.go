@@ -0,0 +1,57 @@
+// Copyright (c) 2018-2019 Aalborg University
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package lab
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aau-network-security/go-ntp/virtual/runtime"
+	"github.com/aau-network-security/haaukins/virtual/vbox"
+)
+
+// fakeDriverAwareHost satisfies LabHost (as far as this package's own usage
+// of it, i.e. NewLab, requires) and also DriverAware, the same shape the
+// real labHost needs to take on for withDriver to have any effect.
+type fakeDriverAwareHost struct {
+	driver runtime.Driver
+}
+
+func (f *fakeDriverAwareHost) SetDriver(d runtime.Driver) { f.driver = d }
+func (f *fakeDriverAwareHost) NewLab(ctx context.Context, vboxLib vbox.Library, conf Config) (Lab, error) {
+	return nil, nil
+}
+
+// fakeOpaqueHost satisfies LabHost but not DriverAware, the shape a host
+// that hasn't opted in yet takes.
+type fakeOpaqueHost struct{}
+
+func (f *fakeOpaqueHost) NewLab(ctx context.Context, vboxLib vbox.Library, conf Config) (Lab, error) {
+	return nil, nil
+}
+
+func TestWithDriverCallsSetDriver(t *testing.T) {
+	driver, err := runtime.NewDriver(runtime.Config{})
+	if err != nil {
+		t.Fatalf("unexpected error building driver: %s", err)
+	}
+
+	host := &fakeDriverAwareHost{}
+	withDriver(host, driver)
+
+	if host.driver != driver {
+		t.Fatalf("withDriver did not pass the driver through to SetDriver")
+	}
+}
+
+func TestWithDriverNoopsOnNonDriverAwareHost(t *testing.T) {
+	driver, err := runtime.NewDriver(runtime.Config{})
+	if err != nil {
+		t.Fatalf("unexpected error building driver: %s", err)
+	}
+
+	// Must not panic when host doesn't implement DriverAware.
+	withDriver(&fakeOpaqueHost{}, driver)
+}
@@ -0,0 +1,283 @@
+// Copyright (c) 2018-2019 Aalborg University
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package lab
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// failureThreshold is how many consecutive failed probes it takes before a
+// buffered lab is pulled out and replaced, so a single flaky check doesn't
+// evict a perfectly good lab.
+const failureThreshold = 3
+
+// Prober is implemented by labs whose backend can be probed for liveness
+// (a TCP dial or HTTP GET against the ports declared per-exercise). Labs
+// that don't implement it are assumed healthy, same as with Checkpointable.
+type Prober interface {
+	Probe() error
+}
+
+// LabHealth is the status of a single buffered lab, as surfaced through
+// Hub.Health() and the admin gRPC.
+type LabHealth struct {
+	Tag         string
+	Healthy     bool
+	Failures    int
+	Quarantined bool
+}
+
+type healthEntry struct {
+	lab      Lab
+	failures int
+}
+
+// HealthChecker periodically probes every lab sitting in a hub's buffer and
+// quarantines the ones that stop answering, instead of letting them leak to
+// the next student who calls Get().
+type HealthChecker struct {
+	h        *hub
+	interval time.Duration
+
+	m          sync.Mutex
+	tracked    map[string]*healthEntry
+	quarantine map[string]Lab
+
+	stop chan struct{}
+}
+
+// NewHealthChecker starts probing h's buffer every interval. Call Stop to
+// shut it down when the hub closes.
+func NewHealthChecker(h *hub, interval time.Duration) *HealthChecker {
+	hc := &HealthChecker{
+		h:          h,
+		interval:   interval,
+		tracked:    make(map[string]*healthEntry),
+		quarantine: make(map[string]Lab),
+		stop:       make(chan struct{}),
+	}
+	go hc.run()
+	return hc
+}
+
+func (hc *HealthChecker) run() {
+	ticker := time.NewTicker(hc.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			hc.probeAll()
+		case <-hc.stop:
+			return
+		}
+	}
+}
+
+func (hc *HealthChecker) Stop() {
+	close(hc.stop)
+}
+
+// probeAll checks every lab still sitting unclaimed in the buffer. Labs
+// already handed out via Get() are a student's responsibility, not the
+// warm pool's, so they're deliberately excluded here.
+func (hc *HealthChecker) probeAll() {
+	labs := hc.h.bufferedLabs()
+
+	// Forget labs that have left the buffer since the last round, so
+	// Health only reports ones still sitting in it.
+	buffered := make(map[string]bool, len(labs))
+	for _, lab := range labs {
+		buffered[lab.GetTag()] = true
+	}
+	hc.m.Lock()
+	for tag := range hc.tracked {
+		if !buffered[tag] {
+			delete(hc.tracked, tag)
+		}
+	}
+	hc.m.Unlock()
+
+	for _, lab := range labs {
+		hc.probe(lab)
+	}
+}
+
+func (hc *HealthChecker) probe(l Lab) {
+	prober, ok := l.(Prober)
+	if !ok {
+		return
+	}
+
+	tag := l.GetTag()
+
+	hc.m.Lock()
+	entry, ok := hc.tracked[tag]
+	if !ok {
+		entry = &healthEntry{lab: l}
+		hc.tracked[tag] = entry
+	}
+	hc.m.Unlock()
+
+	if err := prober.Probe(); err != nil {
+		hc.m.Lock()
+		entry.failures++
+		failures := entry.failures
+		hc.m.Unlock()
+
+		log.Warn().Msgf("health probe failed for lab %s (%d/%d): %s", tag, failures, failureThreshold, err)
+
+		if failures >= failureThreshold {
+			hc.quarantineLab(l)
+		}
+		return
+	}
+
+	hc.m.Lock()
+	entry.failures = 0
+	hc.m.Unlock()
+}
+
+// quarantineLab removes l from circulation, closes it asynchronously, and
+// kicks addLab to refill the slot it held.
+func (hc *HealthChecker) quarantineLab(l Lab) {
+	tag := l.GetTag()
+
+	hc.m.Lock()
+	if _, already := hc.quarantine[tag]; already {
+		hc.m.Unlock()
+		return
+	}
+	hc.quarantine[tag] = l
+	delete(hc.tracked, tag)
+	hc.m.Unlock()
+
+	log.Warn().Msgf("quarantining unhealthy lab %s", tag)
+
+	if !hc.h.evict(l) {
+		// Already claimed by a student between the failing probe and now;
+		// nothing left for us to do.
+		hc.m.Lock()
+		delete(hc.quarantine, tag)
+		hc.m.Unlock()
+		return
+	}
+
+	go func() {
+		if err := l.Close(); err != nil {
+			log.Warn().Msgf("error while closing quarantined lab %s: %s", tag, err)
+		}
+		hc.m.Lock()
+		delete(hc.quarantine, tag)
+		hc.m.Unlock()
+	}()
+
+	go func() {
+		if err := hc.h.addLab(); err != nil {
+			log.Warn().Msgf("error while refilling after quarantine: %s", err)
+		}
+	}()
+}
+
+// Health reports the current health state of every lab sitting in the
+// buffer that implements Prober and has been probed at least once, plus
+// anything currently being evicted. Labs that don't implement Prober are
+// not listed.
+func (hc *HealthChecker) Health() []LabHealth {
+	hc.m.Lock()
+	defer hc.m.Unlock()
+
+	var out []LabHealth
+	for tag, e := range hc.tracked {
+		out = append(out, LabHealth{
+			Tag:      tag,
+			Healthy:  e.failures == 0,
+			Failures: e.failures,
+		})
+	}
+	for tag := range hc.quarantine {
+		out = append(out, LabHealth{Tag: tag, Quarantined: true})
+	}
+
+	return out
+}
+
+// bufferedLabs snapshots every lab currently sitting unclaimed in h.buffer,
+// without removing any of them, for HealthChecker to probe. It uses the
+// same drain-and-refill trick as evict so the buffer's contents (and FIFO
+// order) are unchanged once it returns.
+func (h *hub) bufferedLabs() []Lab {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.closed {
+		return nil
+	}
+
+	var labs []Lab
+
+drain:
+	for {
+		select {
+		case l := <-h.buffer:
+			labs = append(labs, l)
+		default:
+			break drain
+		}
+	}
+
+	for _, l := range labs {
+		h.buffer <- l
+	}
+
+	return labs
+}
+
+// evict pulls lab out of h's buffer if it's still sitting there unclaimed,
+// draining the buffer into a holding slice and pushing everything back
+// except lab. It decrements numbLabs, as Get() does for a claimed lab, and
+// unlike Get() also releases the maximumSema slot lab held, since lab is
+// gone for good. Returns false if lab was already claimed by a caller of
+// Get() before eviction happened, or if the hub is closing.
+func (h *hub) evict(target Lab) bool {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if h.closed {
+		return false
+	}
+
+	var kept []Lab
+	found := false
+
+drain:
+	for {
+		select {
+		case l := <-h.buffer:
+			if l == target {
+				found = true
+				continue
+			}
+			kept = append(kept, l)
+		default:
+			break drain
+		}
+	}
+
+	for _, l := range kept {
+		h.buffer <- l
+	}
+
+	if found {
+		atomic.AddInt32(&h.numbLabs, -1)
+		h.maximumSema.release()
+	}
+
+	return found
+}
@@ -9,12 +9,14 @@ import (
 	"errors"
 	"github.com/aau-network-security/haaukins/logging"
 
+	"github.com/aau-network-security/go-ntp/virtual/runtime"
 	"github.com/aau-network-security/haaukins/store"
 	"github.com/aau-network-security/haaukins/virtual/vbox"
 	"github.com/rs/zerolog/log"
 	"io"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var (
@@ -26,12 +28,16 @@ var (
 const BUFFERSIZE = 5
 
 
+// healthCheckInterval is how often a hub's HealthChecker probes the buffer.
+const healthCheckInterval = 30 * time.Second
+
 type Hub interface {
 	Get() (Lab, error)
 	Available() int32
 	Flags() []store.FlagConfig
 	GetLabs() []Lab
 	GetLabByTag(tag string) (Lab, error)
+	Health() []LabHealth
 	io.Closer
 }
 
@@ -48,31 +54,89 @@ type hub struct {
 	labs     []Lab
 	buffer   chan Lab
 	numbLabs int32
-}
 
+	// checkpointDir is where persistBuffer/restoreCheckpoints read and write
+	// snapshots of the buffer's labs. Empty disables checkpointing, which
+	// keeps NewHub/NewHubWithScaler callers unaffected; it's set through
+	// NewHubWithDriver, the fullest constructor in this chain.
+	checkpointDir string
+
+	// closed is set under m before buffer is ever closed, and every other
+	// goroutine that might send on buffer (addLab) checks it under the same
+	// lock first. A send on buffer is therefore never attempted once Close
+	// has started, which is what actually prevents the "send on closed
+	// channel" panic — select's default case does NOT protect a send
+	// against a concurrent close.
+	closed bool
+
+	scaler     *AutoScaler
+	scalerStop chan struct{}
+
+	health *HealthChecker
+}
 
 func NewHub(ctx context.Context, conf Config, vboxLib vbox.Library, available int, cap int) (Hub, error) {
+	return NewHubWithScaler(ctx, conf, vboxLib, available, cap, DefaultScalerConfig)
+}
+
+// NewHubWithScaler is NewHub with explicit AutoScaler tuning; NewHub just
+// calls this with DefaultScalerConfig so existing callers are unaffected.
+func NewHubWithScaler(ctx context.Context, conf Config, vboxLib vbox.Library, available int, cap int, scalerConf ScalerConfig) (Hub, error) {
+	driver, err := runtime.NewDriver(runtime.Config{})
+	if err != nil {
+		return nil, err
+	}
+	return NewHubWithDriver(ctx, conf, vboxLib, driver, available, cap, scalerConf, "")
+}
+
+// NewHubWithDriver is NewHubWithScaler with an explicit runtime.Driver and a
+// checkpointDir: letting operators pick Docker or Podman (or anything else
+// runtime.Driver grows) at hub-construction time instead of always getting
+// the driver runtime.NewDriver(runtime.Config{}) defaults to, and opt into
+// persisting the buffer across restarts by passing a non-empty
+// checkpointDir (NewHub/NewHubWithScaler pass "", which disables it).
+// driver is handed to the lab host through withDriver, so it only reaches
+// the labs when the host implements DriverAware; otherwise it is dropped.
+func NewHubWithDriver(ctx context.Context, conf Config, vboxLib vbox.Library, driver runtime.Driver, available int, cap int, scalerConf ScalerConfig, checkpointDir string) (Hub, error) {
 	if available > cap {
 		return nil, AvailableSizeErr
 	}
 	createLimit := 3
+	labHost := &labHost{}
+	withDriver(labHost, driver)
+
 	h := &hub{
-		labs:        []Lab{},
-		conf:        conf,
-		createSema:  newSemaphore(createLimit),
-		maximumSema: newSemaphore(cap),
-		ctx:         context.Background(),
-		buffer:      make(chan Lab, available),
-		vboxLib:     vboxLib,
-		labHost:     &labHost{},
+		labs:          []Lab{},
+		conf:          conf,
+		createSema:    newSemaphore(createLimit),
+		maximumSema:   newSemaphore(cap),
+		ctx:           context.Background(),
+		buffer:        make(chan Lab, cap),
+		vboxLib:       vboxLib,
+		labHost:       labHost,
+		scaler:        NewAutoScaler(scalerConf),
+		scalerStop:    make(chan struct{}),
+		checkpointDir: checkpointDir,
 	}
-	h.init(ctx, available)
+	go h.scaler.Run(h, h.scalerStop)
+	h.health = NewHealthChecker(h, healthCheckInterval)
+
+	restored := h.restoreCheckpoints()
+	if restored > 0 {
+		log.Debug().Msgf("Restored %d lab(s) from checkpoint", restored)
+		atomic.AddInt32(&h.numbLabs, int32(restored))
+	}
+
+	h.init(ctx, available-restored)
 
 	return h, nil
 }
 
 
 func (h *hub) init(ctx context.Context, available int) error {
+	if available < 0 {
+		available = 0
+	}
 	grpcLogger := logging.LoggerFromCtx(ctx)
 	log.Debug().Msgf("Instantiating %d lab(s)", available)
 	var wg sync.WaitGroup
@@ -117,14 +181,19 @@ func (h *hub) addLab() error {
 	h.createSema.claim()
 	defer h.createSema.release()
 
+	start := time.Now()
+	defer func() { h.scaler.RecordAddLabDuration(time.Since(start)) }()
+
 	lab, err := h.labHost.NewLab(h.ctx, h.vboxLib, h.conf)
 	if err != nil {
 		log.Debug().Msgf("Error while creating new lab: %s", err)
+		h.maximumSema.release()
 		return err
 	}
 
 	if err := lab.Start(h.ctx); err != nil {
 		log.Warn().Msgf("Error while starting lab: %s", err)
+		h.maximumSema.release()
 		go func(lab Lab) {
 			if err := lab.Close(); err != nil {
 				log.Warn().Msgf("Error while closing lab: %s", err)
@@ -133,11 +202,27 @@ func (h *hub) addLab() error {
 		return err
 	}
 
-	select {
-	case h.buffer <- lab:
-		atomic.AddInt32(&h.numbLabs, 1)
-	default:
-		// sending on closed channel
+	dropped := false
+	h.m.Lock()
+	if h.closed {
+		dropped = true
+	} else {
+		select {
+		case h.buffer <- lab:
+			atomic.AddInt32(&h.numbLabs, 1)
+		default:
+			dropped = true
+		}
+	}
+	h.m.Unlock()
+
+	if dropped {
+		// Buffer is closing or full: the slot we claimed above would
+		// otherwise leak forever, wedging every future addLab call.
+		h.maximumSema.release()
+		if err := lab.Close(); err != nil {
+			log.Warn().Msgf("Error while closing lab dropped on a full/closing buffer: %s", err)
+		}
 	}
 
 	return nil
@@ -148,20 +233,62 @@ func (h *hub) Available() int32 {
 }
 
 func (h *hub) Get() (Lab, error) {
+	h.scaler.RecordGet(time.Now())
+	actualBufferGauge.Set(float64(atomic.LoadInt32(&h.numbLabs)))
+
+	// buffer is read under m so this can never observe the momentarily
+	// empty channel that bufferedLabs/evict's drain-and-refill produces,
+	// and so it can never race a concurrent Close closing the channel.
+	h.m.Lock()
+	lab, ok := h.receiveLocked()
+	h.m.Unlock()
+	if !ok {
+		return nil, MaximumLabsErr
+	}
+
+	atomic.AddInt32(&h.numbLabs, -1)
+	if atomic.LoadInt32(&h.numbLabs) < int32(h.scaler.Target()) {
+		go func() {
+			if err := h.addLab(); err != nil {
+				log.Warn().Msgf("Error while add lab: %s", err)
+			}
+		}()
+	}
+	h.labs = append(h.labs, lab)
+	return lab, nil
+}
+
+// receiveLocked takes a lab off the buffer without blocking. Callers must
+// already hold h.m.
+func (h *hub) receiveLocked() (Lab, bool) {
 	select {
-	case lab := <-h.buffer:
-		atomic.AddInt32(&h.numbLabs, -1)
-		if atomic.LoadInt32(&h.numbLabs) < BUFFERSIZE {
-			go func() {
-				if err := h.addLab(); err != nil {
-					log.Warn().Msgf("Error while add lab: %s", err)
-				}
-			}()
-		}
-		h.labs = append(h.labs, lab)
-		return lab, nil
+	case lab, ok := <-h.buffer:
+		// ok is false once Close has closed the buffer and it is drained.
+		return lab, ok
 	default:
-		return nil, MaximumLabsErr
+		return nil, false
+	}
+}
+
+// shrinkToTarget closes idle buffered labs down to target once demand
+// drops, freeing the maximumSema slots they held. Labs already claimed via
+// Get() are never touched, only ones still sitting unclaimed in h.buffer.
+func (h *hub) shrinkToTarget(target int) {
+	for int(atomic.LoadInt32(&h.numbLabs)) > target {
+		h.m.Lock()
+		lab, ok := h.receiveLocked()
+		h.m.Unlock()
+		if !ok {
+			return
+		}
+
+		atomic.AddInt32(&h.numbLabs, -1)
+		h.maximumSema.release()
+		go func(lab Lab) {
+			if err := lab.Close(); err != nil {
+				log.Warn().Msgf("error while closing lab during scale-down: %s", err)
+			}
+		}(lab)
 	}
 }
 
@@ -169,6 +296,13 @@ func (h *hub) Close() error {
 	_, cancel := context.WithCancel(h.ctx)
 	cancel()
 
+	h.m.Lock()
+	h.closed = true
+	h.m.Unlock()
+
+	close(h.scalerStop)
+	h.health.Stop()
+	h.persistBuffer()
 	close(h.buffer)
 
 	var wg sync.WaitGroup
@@ -212,6 +346,10 @@ func (h *hub) GetLabByTag(tag string) (Lab, error) {
 	return nil, CouldNotFindLabErr
 }
 
+func (h *hub) Health() []LabHealth {
+	return h.health.Health()
+}
+
 type rsrc struct{}
 
 type semaphore struct {
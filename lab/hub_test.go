@@ -0,0 +1,66 @@
+// Copyright (c) 2018-2019 Aalborg University
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package lab
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aau-network-security/haaukins/virtual/vbox"
+)
+
+// erroringLabHost satisfies LabHost (as far as this package's own usage of
+// it requires) and always fails, so TestHubGetRaceWithClose needs no real
+// backend. In that test every maximumSema slot is already claimed, so the
+// refill goroutines Get() starts return MaximumLabsErr before calling it.
+type erroringLabHost struct{}
+
+func (erroringLabHost) NewLab(ctx context.Context, vboxLib vbox.Library, conf Config) (Lab, error) {
+	return nil, errors.New("no backend in test")
+}
+
+// TestHubGetRaceWithClose drives Get() and Close() concurrently under -race:
+// Close closes h.buffer while Get() calls are still taking labs off it and
+// starting refill goroutines.
+func TestHubGetRaceWithClose(t *testing.T) {
+	const n = 20
+
+	h := &hub{
+		buffer:      make(chan Lab, n),
+		createSema:  newSemaphore(3),
+		maximumSema: newSemaphore(n),
+		ctx:         context.Background(),
+		labHost:     erroringLabHost{},
+		scaler:      NewAutoScaler(ScalerConfig{MinAvailable: 1, MaxAvailable: n, ScaleAlpha: 0.3, CooldownSeconds: 60}),
+		scalerStop:  make(chan struct{}),
+	}
+	h.health = NewHealthChecker(h, time.Hour)
+
+	for i := 0; i < n; i++ {
+		h.maximumSema.claim()
+		h.buffer <- &localLab{}
+		h.numbLabs++
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			h.Get()
+		}()
+	}
+
+	go func() {
+		if err := h.Close(); err != nil {
+			t.Errorf("unexpected error from Close: %s", err)
+		}
+	}()
+
+	wg.Wait()
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2018-2019 Aalborg University
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"github.com/aau-network-security/go-ntp/virtual/docker"
+)
+
+// dockerDriver is the original driver, kept as the default so that existing
+// DockerConfig based deployments are unaffected by the introduction of the
+// runtime package.
+type dockerDriver struct {
+	conf Config
+}
+
+func newDockerDriver(conf Config) *dockerDriver {
+	return &dockerDriver{conf: conf}
+}
+
+func (d *dockerDriver) CreateContainer(conf docker.ContainerConfig) (docker.Container, error) {
+	return docker.NewContainer(conf)
+}
+
+func (d *dockerDriver) StartContainer(id string) error {
+	return docker.NewClient().ContainerStart(id)
+}
+
+func (d *dockerDriver) AttachNetwork(id string, n docker.Network, mac string, ip int) (int, error) {
+	c, err := docker.ContainerFromID(id)
+	if err != nil {
+		return 0, err
+	}
+	if ip != 0 {
+		return n.Connect(c, mac, ip)
+	}
+	return n.Connect(c, mac)
+}
+
+func (d *dockerDriver) SnapshotContainer(id string, dir string) error {
+	return docker.NewClient().ContainerCommit(id, dir)
+}
+
+func (d *dockerDriver) RemoveContainer(id string) error {
+	return docker.NewClient().ContainerRemove(id)
+}
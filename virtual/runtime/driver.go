@@ -0,0 +1,58 @@
+// Copyright (c) 2018-2019 Aalborg University
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+// Package runtime abstracts the container engine used to realize exercise
+// and lab environments, so that the rest of Haaukins does not need to know
+// whether containers are being created through Docker or some other engine.
+package runtime
+
+import (
+	"errors"
+
+	"github.com/aau-network-security/go-ntp/virtual/docker"
+)
+
+var (
+	UnknownKindErr = errors.New("Unknown runtime kind")
+)
+
+// Kind identifies which container engine a Driver talks to.
+type Kind string
+
+const (
+	Docker Kind = "docker"
+	Podman Kind = "podman"
+)
+
+// Driver creates and controls the containers backing an exercise or a lab,
+// regardless of which engine is running on the host.
+type Driver interface {
+	CreateContainer(conf docker.ContainerConfig) (docker.Container, error)
+	StartContainer(id string) error
+	AttachNetwork(id string, n docker.Network, mac string, ip int) (int, error)
+	SnapshotContainer(id string, dir string) error
+	RemoveContainer(id string) error
+}
+
+// Config selects and configures a Driver. The `runtime:` key on the exercise
+// Config maps onto this, with Kind defaulting to Docker so existing
+// DockerConfig-only configuration files keep working unmodified.
+type Config struct {
+	Kind   Kind   `yaml:"kind"`
+	Socket string `yaml:"socket"`
+}
+
+// NewDriver instantiates the Driver selected by conf. An empty Kind is
+// treated as Docker for backwards compatibility with configs written before
+// the runtime key existed.
+func NewDriver(conf Config) (Driver, error) {
+	switch conf.Kind {
+	case "", Docker:
+		return newDockerDriver(conf), nil
+	case Podman:
+		return newPodmanDriver(conf)
+	default:
+		return nil, UnknownKindErr
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright (c) 2018-2019 Aalborg University
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import "testing"
+
+func TestNewDriverDefaultsToDocker(t *testing.T) {
+	tt := []struct {
+		name string
+		kind Kind
+	}{
+		{"empty kind defaults to docker", ""},
+		{"explicit docker", Docker},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := NewDriver(Config{Kind: tc.kind})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if _, ok := d.(*dockerDriver); !ok {
+				t.Fatalf("expected *dockerDriver, got %T", d)
+			}
+		})
+	}
+}
+
+func TestNewDriverUnknownKind(t *testing.T) {
+	if _, err := NewDriver(Config{Kind: "bogus"}); err != UnknownKindErr {
+		t.Fatalf("expected UnknownKindErr, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2018-2019 Aalborg University
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/aau-network-security/go-ntp/virtual/docker"
+	"github.com/containers/libpod/v2/libpod"
+	"github.com/containers/libpod/v2/libpod/define"
+)
+
+var NoAssignedIPErr = errors.New("container has no IP on the requested network")
+
+// toPodSpec translates the engine-agnostic docker.ContainerConfig into the
+// libpod spec generator options needed to create an equivalent container
+// without a Docker daemon.
+func toPodSpec(conf docker.ContainerConfig) libpod.PodmanSpecGenerator {
+	spec := libpod.PodmanSpecGenerator{}
+	spec.Image = conf.Image
+	spec.Env = conf.EnvVars
+	if conf.Resources != nil {
+		spec.ResourceLimits = &define.LinuxResources{
+			Memory: &define.LinuxMemory{Limit: int64(conf.Resources.MemoryMB) * 1024 * 1024},
+			CPU:    &define.LinuxCPU{Quota: int64(conf.Resources.CPU * 100000)},
+		}
+	}
+	return spec
+}
+
+// podmanContainer adapts a libpod container to the docker.Container
+// interface so the rest of exercise/lab code can treat it like any other
+// virtual.Instance.
+type podmanContainer struct {
+	ctr *libpod.Container
+}
+
+func newPodmanContainer(ctr *libpod.Container) *podmanContainer {
+	return &podmanContainer{ctr: ctr}
+}
+
+func (p *podmanContainer) ID() string {
+	return p.ctr.ID()
+}
+
+func (p *podmanContainer) Start() error {
+	return p.ctr.Start(nil, false)
+}
+
+func (p *podmanContainer) Close() error {
+	return p.ctr.Cleanup(nil)
+}
+
+// assignedIPOffset reads back the IP CNI just assigned ctr on n and returns
+// its last octet, the same addressing scheme docker.Network.Connect uses,
+// so callers that didn't pin an IP still get one consistent with the rest
+// of the exercise's DNS records.
+func assignedIPOffset(ctr *libpod.Container, n docker.Network) (int, error) {
+	status, err := ctr.NetworkStatus()
+	if err != nil {
+		return 0, err
+	}
+
+	// Every address on n shares everything up to the last octet with
+	// n.FormatIP's output, so that's the prefix to match against.
+	sample := n.FormatIP(1)
+	prefix := sample[:strings.LastIndex(sample, ".")+1]
+
+	for _, s := range status {
+		for _, ip := range s.IPs {
+			addr := ip.Address.IP.String()
+			if !strings.HasPrefix(addr, prefix) {
+				continue
+			}
+			parts := strings.Split(addr, ".")
+			return strconv.Atoi(parts[len(parts)-1])
+		}
+	}
+
+	return 0, NoAssignedIPErr
+}
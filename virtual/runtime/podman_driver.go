@@ -0,0 +1,105 @@
+// Copyright (c) 2018-2019 Aalborg University
+// Use of this source code is governed by a GPLv3
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"context"
+	"net"
+
+	"github.com/aau-network-security/go-ntp/virtual/docker"
+	"github.com/containers/libpod/v2/libpod"
+)
+
+// podmanDriver talks to a local libpod store directly, so it needs neither a
+// running daemon nor root privileges. This is what lets Haaukins run on
+// hosts that don't ship a Docker daemon.
+type podmanDriver struct {
+	conf    Config
+	runtime *libpod.Runtime
+}
+
+func newPodmanDriver(conf Config) (*podmanDriver, error) {
+	opts := []libpod.RuntimeOption{}
+	if conf.Socket != "" {
+		opts = append(opts, libpod.WithStorageConfig(conf.Socket))
+	}
+
+	rt, err := libpod.NewRuntime(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &podmanDriver{conf: conf, runtime: rt}, nil
+}
+
+func (d *podmanDriver) CreateContainer(conf docker.ContainerConfig) (docker.Container, error) {
+	spec := toPodSpec(conf)
+	ctr, err := d.runtime.NewContainer(context.Background(), spec)
+	if err != nil {
+		return nil, err
+	}
+	return newPodmanContainer(ctr), nil
+}
+
+func (d *podmanDriver) StartContainer(id string) error {
+	ctr, err := d.runtime.LookupContainer(id)
+	if err != nil {
+		return err
+	}
+	return ctr.Start(context.Background(), false)
+}
+
+// AttachNetwork joins a libpod container to the exercise network entirely
+// through libpod's own CNI integration. It deliberately never goes through
+// the Docker API (no docker.ContainerFromID lookup): a container created by
+// this driver only exists in the local libpod store, and on the rootless,
+// daemon-less hosts this driver targets there is no Docker API to look it
+// up in.
+func (d *podmanDriver) AttachNetwork(id string, n docker.Network, mac string, ip int) (int, error) {
+	ctr, err := d.runtime.LookupContainer(id)
+	if err != nil {
+		return 0, err
+	}
+
+	opts := libpod.NetworkConnectOptions{
+		InterfaceName: n.Interface(),
+		StaticMAC:     mac,
+	}
+	if ip != 0 {
+		opts.StaticIPs = []net.IP{net.ParseIP(n.FormatIP(ip))}
+	}
+
+	if err := d.runtime.ConnectContainerToNetwork(context.Background(), ctr, n.Interface(), opts); err != nil {
+		return 0, err
+	}
+
+	if ip == 0 {
+		ip, err = assignedIPOffset(ctr, n)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return ip, nil
+}
+
+func (d *podmanDriver) SnapshotContainer(id string, dir string) error {
+	ctr, err := d.runtime.LookupContainer(id)
+	if err != nil {
+		return err
+	}
+	opts := libpod.ContainerCheckpointOptions{
+		TargetFile: dir,
+	}
+	return d.runtime.CheckpointContainer(context.Background(), ctr, opts)
+}
+
+func (d *podmanDriver) RemoveContainer(id string) error {
+	ctr, err := d.runtime.LookupContainer(id)
+	if err != nil {
+		return err
+	}
+	return d.runtime.RemoveContainer(context.Background(), ctr, true, false)
+}